@@ -0,0 +1,88 @@
+// Copyright (c) 2012, Sean Treadway, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/streadway/amqp
+
+package amqp
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Authentication interface provides a means for different SASL
+// authentication mechanisms to be used during connection.start-ok and any
+// subsequent connection.secure/secure-ok rounds requested by the server.
+type Authentication interface {
+	Mechanism() string
+	Response() string
+
+	// Challenge is invoked for each connection.secure frame the server
+	// sends, with the opaque challenge it supplied, and returns the
+	// opaque response to send back in connection.secure-ok.  Mechanisms
+	// that don't support challenge/response can return an error.
+	Challenge(challenge []byte) ([]byte, error)
+}
+
+// PlainAuth is the default SASL mechanism, sending the Username and
+// Password in the clear as a single response with no further challenges.
+type PlainAuth struct {
+	Username string
+	Password string
+}
+
+func (auth *PlainAuth) Mechanism() string {
+	return "PLAIN"
+}
+
+func (auth *PlainAuth) Response() string {
+	return fmt.Sprintf("\000%s\000%s", auth.Username, auth.Password)
+}
+
+func (auth *PlainAuth) Challenge(challenge []byte) ([]byte, error) {
+	return nil, fmt.Errorf("PLAIN does not support challenge/response, got challenge %q", challenge)
+}
+
+// AMQPLAINAuth sends the Username and Password table-encoded as AMQPLAIN
+// expects, rather than PLAIN's NUL-delimited string, for brokers that only
+// offer the AMQPLAIN mechanism.
+type AMQPLAINAuth struct {
+	Username string
+	Password string
+}
+
+func (auth *AMQPLAINAuth) Mechanism() string {
+	return "AMQPLAIN"
+}
+
+func (auth *AMQPLAINAuth) Response() string {
+	var buf bytes.Buffer
+
+	writeTable(&buf, Table{
+		"LOGIN":    auth.Username,
+		"PASSWORD": auth.Password,
+	})
+
+	return buf.String()
+}
+
+func (auth *AMQPLAINAuth) Challenge(challenge []byte) ([]byte, error) {
+	return nil, fmt.Errorf("AMQPLAIN does not support challenge/response, got challenge %q", challenge)
+}
+
+// ExternalAuth relies on the transport - typically a TLS client
+// certificate - to establish identity, sending an empty initial response
+// and answering any challenge with another empty response.
+type ExternalAuth struct{}
+
+func (auth *ExternalAuth) Mechanism() string {
+	return "EXTERNAL"
+}
+
+func (auth *ExternalAuth) Response() string {
+	return ""
+}
+
+func (auth *ExternalAuth) Challenge(challenge []byte) ([]byte, error) {
+	return []byte{}, nil
+}
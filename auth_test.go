@@ -0,0 +1,52 @@
+// Copyright (c) 2012, Sean Treadway, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/streadway/amqp
+
+package amqp
+
+import "testing"
+
+func TestPlainAuthMechanismAndResponse(t *testing.T) {
+	auth := &PlainAuth{Username: "guest", Password: "guest"}
+
+	if auth.Mechanism() != "PLAIN" {
+		t.Fatalf("expected mechanism PLAIN, got %q", auth.Mechanism())
+	}
+	if want := "\000guest\000guest"; auth.Response() != want {
+		t.Fatalf("Response() = %q, want %q", auth.Response(), want)
+	}
+	if _, err := auth.Challenge([]byte("nope")); err == nil {
+		t.Fatalf("expected PLAIN to reject a challenge, got nil error")
+	}
+}
+
+func TestExternalAuthIsEmptyAndAnswersChallenges(t *testing.T) {
+	auth := &ExternalAuth{}
+
+	if auth.Mechanism() != "EXTERNAL" {
+		t.Fatalf("expected mechanism EXTERNAL, got %q", auth.Mechanism())
+	}
+	if auth.Response() != "" {
+		t.Fatalf("expected an empty initial response, got %q", auth.Response())
+	}
+
+	resp, err := auth.Challenge([]byte("anything"))
+	if err != nil {
+		t.Fatalf("expected EXTERNAL to answer any challenge, got error: %v", err)
+	}
+	if len(resp) != 0 {
+		t.Fatalf("expected an empty challenge response, got %q", resp)
+	}
+}
+
+func TestAMQPLAINAuthMechanism(t *testing.T) {
+	auth := &AMQPLAINAuth{Username: "guest", Password: "guest"}
+
+	if auth.Mechanism() != "AMQPLAIN" {
+		t.Fatalf("expected mechanism AMQPLAIN, got %q", auth.Mechanism())
+	}
+	if _, err := auth.Challenge([]byte("nope")); err == nil {
+		t.Fatalf("expected AMQPLAIN to reject a challenge, got nil error")
+	}
+}
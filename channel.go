@@ -0,0 +1,123 @@
+// Copyright (c) 2012, Sean Treadway, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/streadway/amqp
+
+package amqp
+
+// Confirm puts this channel into confirm mode so that the client can ensure
+// all publishings have successfully been received by the server.  After
+// entering this mode, the server will send a basic.ack or basic.nack message
+// with the deliver tag set to a 1 based incremental index corresponding to
+// every publishing received after the this method returns.
+//
+// Tag sequences will begin at 1 on the first publishing after Confirm.
+//
+// Publishings that are delivered to a mandatory or immediate queue that are
+// returned will also be confirmed and the Ack or Nack will be sent after the
+// Return.
+//
+// Ack and Nack confirmations will arrive in the order in which the
+// publishings occurred, but may be out of order relative to each other on
+// the wire, which this channel resequences before delivery to NotifyPublish
+// listeners.
+//
+// Not all servers will support this feature.  To request this feature prior
+// to publishing, check the server capabilities for "publisher_confirms".
+func (me *Channel) Confirm(noWait bool) error {
+	if !me.connection.isCapable("publisher_confirms") {
+		return ErrCommandInvalid
+	}
+
+	if err := me.call(
+		&confirmSelect{Nowait: noWait},
+		&confirmSelectOk{},
+	); err != nil {
+		return err
+	}
+
+	me.confirmM.Lock()
+	me.confirming = true
+	if me.confirms == nil {
+		me.confirms = newConfirms()
+	}
+	me.confirmM.Unlock()
+
+	return nil
+}
+
+// NotifyPublish registers a listener for basic.ack and basic.nack
+// confirmations.  The channel must be put into confirm mode with Confirm
+// before any confirmations will be delivered.  Each Confirmation carries the
+// delivery tag assigned at publish time and whether it was ack'd or nack'd,
+// with "multiple" tags already expanded into one Confirmation per tag.
+//
+// The listener chan will be closed when the Channel is closed, so callers
+// that are waiting on a confirmation should also select on NotifyClose to
+// avoid blocking forever when a publish is in flight during a shutdown.
+func (me *Channel) NotifyPublish(confirm chan Confirmation) chan Confirmation {
+	me.confirmM.Lock()
+	defer me.confirmM.Unlock()
+
+	if me.confirms == nil {
+		me.confirms = newConfirms()
+	}
+
+	if me.noNotify {
+		close(confirm)
+	} else {
+		me.confirms.Listen(confirm)
+	}
+
+	return confirm
+}
+
+// nextPublishSeqNo returns the delivery tag that will be assigned to the
+// next basic.publish, or 0 when the channel has not been put into confirm
+// mode.  It must only be called immediately before sending the publish so
+// that the sequence assigned here matches the order frames hit the wire.
+func (me *Channel) nextPublishSeqNo() uint64 {
+	me.confirmM.Lock()
+	confirming := me.confirming
+	me.confirmM.Unlock()
+
+	if !confirming {
+		return 0
+	}
+
+	return me.confirms.Publish()
+}
+
+// dispatchConfirm resequences a basic.ack/basic.nack method and notifies any
+// NotifyPublish listeners, expanding the "multiple" bit into one
+// Confirmation per outstanding delivery tag.
+func (me *Channel) dispatchConfirm(tag uint64, multiple bool, ack bool) {
+	me.confirmM.Lock()
+	c := me.confirms
+	me.confirmM.Unlock()
+
+	if c == nil {
+		return
+	}
+
+	confirmation := Confirmation{DeliveryTag: tag, Ack: ack}
+
+	if multiple {
+		c.Multiple(confirmation)
+	} else {
+		c.One(confirmation)
+	}
+}
+
+// shutdown nacks any outstanding unconfirmed publishings and releases the
+// confirms sequencer so NotifyPublish listeners are closed instead of
+// blocking forever on a tag this channel will never receive.
+func (me *Channel) shutdown(err *Error) {
+	me.confirmM.Lock()
+	c := me.confirms
+	me.confirmM.Unlock()
+
+	if c != nil {
+		c.Close()
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2012, Sean Treadway, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/streadway/amqp
+
+package amqp
+
+import "sync"
+
+// Confirmation notifies the acknowledgment or negative acknowledgement of a
+// publishing identified by its delivery tag.  Use NotifyPublish on the Channel
+// to consume these events.
+type Confirmation struct {
+	DeliveryTag uint64
+	Ack         bool // True when the server successfully received the publishing
+}
+
+// confirms resequences and notifies one or multiple publisher confirmations
+type confirms struct {
+	m         sync.Mutex
+	listeners []chan Confirmation
+	sequencer map[uint64]Confirmation
+	published uint64
+	expecting uint64
+}
+
+// newConfirms allocates a confirms ready to begin publishing counts starting
+// from 1
+func newConfirms() *confirms {
+	return &confirms{
+		sequencer: map[uint64]Confirmation{},
+		expecting: 1,
+	}
+}
+
+// Listen adds a listener to be notified as confirmations arrive in sequence
+func (c *confirms) Listen(l chan Confirmation) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.listeners = append(c.listeners, l)
+}
+
+// Publish increments the publishing counter and returns the delivery tag
+// that will be assigned to this publishing, starting at 1
+func (c *confirms) Publish() uint64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.published++
+	return c.published
+}
+
+// confirm confirms one publishing and all following in the publishing
+// sequence that have already arrived out of order
+func (c *confirms) confirm(confirmation Confirmation) {
+	delete(c.sequencer, c.expecting)
+	c.expecting++
+	for _, l := range c.listeners {
+		l <- confirmation
+	}
+}
+
+// resequence notifies any out of order confirmations that are now in order
+func (c *confirms) resequence() {
+	for c.expecting <= c.published {
+		sequenced, found := c.sequencer[c.expecting]
+		if !found {
+			return
+		}
+		c.confirm(sequenced)
+	}
+}
+
+// One confirms one publishing identified by its delivery tag, out of order
+// confirmations are buffered until the gap in the sequence is filled in
+func (c *confirms) One(confirmed Confirmation) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.expecting == confirmed.DeliveryTag {
+		c.confirm(confirmed)
+	} else {
+		c.sequencer[confirmed.DeliveryTag] = confirmed
+	}
+	c.resequence()
+}
+
+// Multiple confirms all unconfirmed publishings up to and including the
+// delivery tag, per the AMQP 0.9.1 "multiple" bit semantics
+func (c *confirms) Multiple(confirmed Confirmation) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for c.expecting <= confirmed.DeliveryTag {
+		c.confirm(Confirmation{c.expecting, confirmed.Ack})
+	}
+	c.resequence()
+}
+
+// Close nacks all unconfirmed publishings and closes all listeners, used
+// when a channel is torn down with outstanding confirmations so that
+// callers never block forever waiting on a tag that will never arrive
+func (c *confirms) Close() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for tag := c.expecting; tag <= c.published; tag++ {
+		if confirmed, found := c.sequencer[tag]; found {
+			for _, l := range c.listeners {
+				l <- confirmed
+			}
+		} else {
+			for _, l := range c.listeners {
+				l <- Confirmation{DeliveryTag: tag, Ack: false}
+			}
+		}
+	}
+
+	for _, l := range c.listeners {
+		close(l)
+	}
+	c.listeners = nil
+
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2012, Sean Treadway, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/streadway/amqp
+
+package amqp
+
+import "testing"
+
+func TestConfirmsOneInOrder(t *testing.T) {
+	c := newConfirms()
+	l := make(chan Confirmation, 2)
+	c.Listen(l)
+
+	c.Publish()
+	c.Publish()
+
+	c.One(Confirmation{DeliveryTag: 1, Ack: true})
+	c.One(Confirmation{DeliveryTag: 2, Ack: true})
+
+	for tag := uint64(1); tag <= 2; tag++ {
+		if got := <-l; got.DeliveryTag != tag || !got.Ack {
+			t.Fatalf("expected in-order ack for tag %d, got %+v", tag, got)
+		}
+	}
+}
+
+func TestConfirmsOneOutOfOrder(t *testing.T) {
+	c := newConfirms()
+	l := make(chan Confirmation, 2)
+	c.Listen(l)
+
+	c.Publish()
+	c.Publish()
+
+	// tag 2 arrives before tag 1 is confirmed; it must be buffered, not
+	// delivered, until the gap is filled.
+	c.One(Confirmation{DeliveryTag: 2, Ack: true})
+
+	select {
+	case got := <-l:
+		t.Fatalf("expected tag 2 to be buffered until tag 1 arrives, got %+v", got)
+	default:
+	}
+
+	c.One(Confirmation{DeliveryTag: 1, Ack: true})
+
+	for tag := uint64(1); tag <= 2; tag++ {
+		if got := <-l; got.DeliveryTag != tag || !got.Ack {
+			t.Fatalf("expected resequenced ack for tag %d, got %+v", tag, got)
+		}
+	}
+}
+
+func TestConfirmsMultipleDeliversEveryOutstandingTag(t *testing.T) {
+	c := newConfirms()
+	l := make(chan Confirmation, 3)
+	c.Listen(l)
+
+	c.Publish()
+	c.Publish()
+	c.Publish()
+
+	c.Multiple(Confirmation{DeliveryTag: 3, Ack: true})
+
+	for tag := uint64(1); tag <= 3; tag++ {
+		select {
+		case got := <-l:
+			if got.DeliveryTag != tag || !got.Ack {
+				t.Fatalf("expected ack for tag %d, got %+v", tag, got)
+			}
+		default:
+			t.Fatalf("expected tag %d to be delivered via the multiple bit", tag)
+		}
+	}
+}
+
+func TestConfirmsCloseNacksOutstanding(t *testing.T) {
+	c := newConfirms()
+	l := make(chan Confirmation, 2)
+	c.Listen(l)
+
+	c.Publish()
+	c.Publish()
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	for tag := uint64(1); tag <= 2; tag++ {
+		got, ok := <-l
+		if !ok {
+			t.Fatalf("expected a nack for tag %d before the listener closed", tag)
+		}
+		if got.DeliveryTag != tag || got.Ack {
+			t.Fatalf("expected nack for outstanding tag %d, got %+v", tag, got)
+		}
+	}
+
+	if _, ok := <-l; ok {
+		t.Fatalf("expected listener to be closed after Close")
+	}
+}
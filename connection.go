@@ -7,6 +7,7 @@ package amqp
 
 import (
 	"bufio"
+	"crypto/tls"
 	"io"
 	"net"
 	"reflect"
@@ -30,8 +31,54 @@ type Config struct {
 	Channels  int           // 0 max channels means unlimited
 	FrameSize int           // 0 max bytes means unlimited
 	Heartbeat time.Duration // less than 1s interval means no heartbeats
+
+	// TLSClientConfig specifies the client configuration of the TLS
+	// connection when establishing a tls.Conn for an "amqps://" scheme.  If
+	// the URI scheme is "amqps" and this is nil, a TLSClientConfig with the
+	// ServerName derived from the URI host is used.
+	TLSClientConfig *tls.Config
+
+	// Dial accepts a network and address and returns the connection used to
+	// open the AMQP session.  When nil, defaults to net.Dial with a 30
+	// second timeout.
+	Dial func(network, addr string) (net.Conn, error)
+
+	// Properties is the set of client properties sent to the server in
+	// connection.start-ok.  When nil, a default Table identifying this
+	// library is sent.  Set Properties["capabilities"] to a Table with a
+	// "connection.blocked" key set to true (the default) to advertise
+	// support for the connection.blocked/connection.unblocked extension.
+	Properties Table
+
+	// WriteTimeout bounds how long a single WriteFrame may block when the
+	// underlying conn implements SetWriteDeadline, guarding against a
+	// wedged socket stalling every publisher and the heartbeater
+	// goroutine.  When zero, it defaults to three times the negotiated
+	// Heartbeat, mirroring the read side's deadline.
+	WriteTimeout time.Duration
+}
+
+// FrameMinSize is the minimum frame size, in bytes, that any AMQP 0.9.1
+// peer must accept per spec.  Connection.FrameMax is clamped to this floor
+// since some brokers advertise a frame-max of 0 to mean "no limit".
+const FrameMinSize = 4096
+
+// defaultClientProperties returns the client properties sent in
+// connection.start-ok when Config.Properties is not set, advertising
+// support for the connection.blocked extension.
+func defaultClientProperties() Table {
+	return Table{
+		"product":  "https://github.com/chenggangschool/amqp",
+		"version":  buildVersion,
+		"platform": "Go",
+		"capabilities": Table{
+			"connection.blocked": true,
+		},
+	}
 }
 
+const buildVersion = "1.0.0"
+
 // Manages the serialization and deserialization of frames from IO and
 // dispatches the frames to the appropriate channel.
 type Connection struct {
@@ -48,6 +95,7 @@ type Connection struct {
 	channels map[uint16]*Channel
 
 	closes []chan *Error
+	blocks []chan Blocking
 
 	errors chan *Error
 
@@ -63,32 +111,92 @@ type readDeadliner interface {
 	SetReadDeadline(time.Time) error
 }
 
+type writeDeadliner interface {
+	SetWriteDeadline(time.Time) error
+}
+
 // Dial accepts a string in the AMQP URI format, and returns a new Connection
 // over TCP using PlainAuth.  Defaults to a server heartbeat interval of 10
-// seconds and sets the initial read deadline to 30 seconds.
+// seconds and sets the initial read deadline to 30 seconds.  Accepts both
+// "amqp://" and "amqps://" URIs, dialing the latter over TLS.
 func Dial(amqp string) (*Connection, error) {
+	return DialConfig(amqp, Config{
+		Heartbeat: 10 * time.Second,
+	})
+}
+
+// DialTLS accepts a string in the AMQP URI format, and returns a new
+// Connection over TCP using PlainAuth wrapped in a TLS client connection
+// configured with the provided *tls.Config.  Defaults to a server heartbeat
+// interval of 10 seconds and sets the initial read deadline to 30 seconds.
+// The TLS wrapping and cfg are applied only to an "amqps://" URI; an
+// "amqp://" URI is dialed over plain TCP and cfg is not used.
+func DialTLS(amqp string, cfg *tls.Config) (*Connection, error) {
+	return DialConfig(amqp, Config{
+		Heartbeat:       10 * time.Second,
+		TLSClientConfig: cfg,
+	})
+}
+
+// DialConfig accepts a string in the AMQP URI format, and a Config struct
+// that configures the SASL mechanisms, vhost, tuning parameters, dial
+// function and TLS settings to use when connecting.  The URI scheme
+// ("amqp://" or "amqps://") determines whether the underlying net.Conn is
+// wrapped with tls.Client; the Vhost and SASL mechanism default to those
+// parsed from the URI unless already set on the Config.
+func DialConfig(amqp string, config Config) (*Connection, error) {
 	uri, err := ParseURI(amqp)
 	if err != nil {
 		return nil, err
 	}
 
+	if config.SASL == nil {
+		config.SASL = []Authentication{uri.PlainAuth()}
+	}
+
+	if config.Vhost == "" {
+		config.Vhost = uri.Vhost
+	}
+
+	dialer := config.Dial
+	if dialer == nil {
+		dialer = func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout(network, addr, 30*time.Second)
+		}
+	}
+
 	addr := net.JoinHostPort(uri.Host, strconv.FormatInt(int64(uri.Port), 10))
 
-	conn, err := net.DialTimeout("tcp", addr, 30*time.Second)
+	conn, err := dialer("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
 
+	if uri.Scheme == "amqps" {
+		tlsConfig := config.TLSClientConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = uri.Host
+		}
+
+		client := tls.Client(conn, tlsConfig)
+		if err := client.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = client
+	}
+
 	// Heartbeating hasn't started yet, don't stall forever on a dead server.
 	if err := conn.SetReadDeadline(time.Now().Add(30 * time.Second)); err != nil {
+		conn.Close()
 		return nil, err
 	}
 
-	return Open(conn, Config{
-		SASL:      []Authentication{uri.PlainAuth()},
-		Vhost:     uri.Vhost,
-		Heartbeat: 10 * time.Second,
-	})
+	return Open(conn, config)
 }
 
 func Open(conn io.ReadWriteCloser, config Config) (*Connection, error) {
@@ -119,6 +227,30 @@ func (me *Connection) NotifyClose(c chan *Error) chan *Error {
 	return c
 }
 
+// Blocking notifies the server's TCP flow control status as reported by a
+// connection.blocked or connection.unblocked method.  When Active is true,
+// the server has asked the client to stop publishing until it sees a
+// matching Blocking with Active set to false, giving the reason the server
+// applied the block (usually "low on memory" or "low on disk").
+type Blocking struct {
+	Active bool
+	Reason string
+}
+
+// NotifyBlocked registers a listener for RabbitMQ's connection.blocked and
+// connection.unblocked extension, sent when the server is low on memory or
+// disk and wants publishers to throttle.  This extension must be advertised
+// in the client's capabilities during connection.start-ok, which happens
+// automatically unless Config.Properties is set without it.
+//
+// The chan will be closed when the Connection is closed.
+func (me *Connection) NotifyBlocked(c chan Blocking) chan Blocking {
+	me.m.Lock()
+	defer me.m.Unlock()
+	me.blocks = append(me.blocks, c)
+	return c
+}
+
 /*
 Requests, and waits for the response to close the AMQP connection.
 
@@ -156,6 +288,11 @@ func (me *Connection) closeWith(err *Error) error {
 
 func (me *Connection) send(f frame) error {
 	me.m.Lock()
+	if me.Config.WriteTimeout > 0 {
+		if c, ok := me.conn.(writeDeadliner); ok {
+			c.SetWriteDeadline(time.Now().Add(me.Config.WriteTimeout))
+		}
+	}
 	err := me.writer.WriteFrame(f)
 	me.m.Unlock()
 
@@ -199,6 +336,10 @@ func (me *Connection) shutdown(err *Error) {
 		close(me.sends)
 		me.sends = nil
 
+		for _, c := range me.blocks {
+			close(c)
+		}
+
 		me.conn.Close()
 
 		for _, c := range me.closes {
@@ -229,6 +370,14 @@ func (me *Connection) dispatch0(f frame) {
 			})
 
 			me.shutdown(newError(m.ReplyCode, m.ReplyText))
+		case *connectionBlocked:
+			for _, c := range me.blocks {
+				c <- Blocking{Active: true, Reason: m.Reason}
+			}
+		case *connectionUnblocked:
+			for _, c := range me.blocks {
+				c <- Blocking{Active: false}
+			}
 		default:
 			me.rpc <- m
 		}
@@ -241,11 +390,27 @@ func (me *Connection) dispatch0(f frame) {
 }
 
 func (me *Connection) dispatchN(f frame) {
-	if channel, ok := me.channels[f.channel()]; ok {
-		channel.recv(channel, f)
-	} else {
+	channel, ok := me.channels[f.channel()]
+	if !ok {
 		me.dispatchClosed(f)
+		return
 	}
+
+	// basic.ack/basic.nack are publisher confirms and are resequenced and
+	// delivered to the channel's NotifyPublish listeners here, rather than
+	// through the channel's regular method/consumer dispatch.
+	if mf, ok := f.(*methodFrame); ok {
+		switch m := mf.Method.(type) {
+		case *basicAck:
+			channel.dispatchConfirm(m.DeliveryTag, m.Multiple, true)
+			return
+		case *basicNack:
+			channel.dispatchConfirm(m.DeliveryTag, m.Multiple, false)
+			return
+		}
+	}
+
+	channel.recv(channel, f)
 }
 
 // section 2.3.7: "When a peer decides to close a channel or connection, it
@@ -349,6 +514,24 @@ func (me *Connection) isCapable(featureName string) bool {
 	return false
 }
 
+// Heartbeat returns the negotiated heartbeat interval, or zero if
+// heartbeats were disabled during connection.tune.
+func (me *Connection) Heartbeat() time.Duration {
+	return me.Config.Heartbeat
+}
+
+// ChannelMax returns the negotiated maximum number of channels allowed on
+// this connection, or zero when unlimited.
+func (me *Connection) ChannelMax() int {
+	return me.Config.Channels
+}
+
+// FrameMax returns the negotiated maximum frame size in bytes, clamped to
+// at least FrameMinSize.
+func (me *Connection) FrameMax() int {
+	return me.Config.FrameSize
+}
+
 // Constructs and opens a unique channel for concurrent operations
 func (me *Connection) Channel() (*Channel, error) {
 	id := me.nextChannelId()
@@ -387,16 +570,19 @@ func (me *Connection) call(req message, res ...message) error {
 	panic("unreachable")
 }
 
-//    Connection          = open-Connection *use-Connection close-Connection
-//    open-Connection     = C:protocol-header
-//                          S:START C:START-OK
-//                          *challenge
-//                          S:TUNE C:TUNE-OK
-//                          C:OPEN S:OPEN-OK
-//    challenge           = S:SECURE C:SECURE-OK
-//    use-Connection      = *channel
-//    close-Connection    = C:CLOSE S:CLOSE-OK
-//                        / S:CLOSE C:CLOSE-OK
+// Connection          = open-Connection *use-Connection close-Connection
+// open-Connection     = C:protocol-header
+//
+//	S:START C:START-OK
+//	*challenge
+//	S:TUNE C:TUNE-OK
+//	C:OPEN S:OPEN-OK
+//
+// challenge           = S:SECURE C:SECURE-OK
+// use-Connection      = *channel
+// close-Connection    = C:CLOSE S:CLOSE-OK
+//
+//	/ S:CLOSE C:CLOSE-OK
 func (me *Connection) open(config Config) error {
 	if err := me.send(&protocolHeader{}); err != nil {
 		return err
@@ -416,8 +602,6 @@ func (me *Connection) openStart(config Config) error {
 	me.Minor = int(start.VersionMinor)
 	me.Properties = Table(start.ServerProperties)
 
-	// eventually support challenge/response here by also responding to
-	// connectionSecure.
 	auth, ok := pickSASLMechanism(config.SASL, strings.Split(start.Mechanisms, " "))
 	if !ok {
 		return ErrSASL
@@ -429,14 +613,59 @@ func (me *Connection) openStart(config Config) error {
 	return me.openTune(config, auth)
 }
 
+// openSecure answers zero or more connection.secure challenges with
+// connection.secure-ok, invoking auth.Challenge for each round, until the
+// server sends connection.tune.
+func (me *Connection) openSecure(auth Authentication) (*connectionTune, error) {
+	for {
+		select {
+		case err := <-me.errors:
+			return nil, err
+
+		case msg := <-me.rpc:
+			switch m := msg.(type) {
+			case *connectionTune:
+				return m, nil
+
+			case *connectionSecure:
+				response, err := auth.Challenge([]byte(m.Challenge))
+				if err != nil {
+					return nil, err
+				}
+
+				if err := me.send(&methodFrame{
+					ChannelId: 0,
+					Method:    &connectionSecureOk{Response: string(response)},
+				}); err != nil {
+					return nil, err
+				}
+
+			default:
+				return nil, ErrCommandInvalid
+			}
+		}
+	}
+}
+
 func (me *Connection) openTune(config Config, auth Authentication) error {
-	ok := &connectionStartOk{
-		Mechanism: auth.Mechanism(),
-		Response:  auth.Response(),
+	properties := config.Properties
+	if properties == nil {
+		properties = defaultClientProperties()
+	}
+
+	if err := me.send(&methodFrame{
+		ChannelId: 0,
+		Method: &connectionStartOk{
+			ClientProperties: properties,
+			Mechanism:        auth.Mechanism(),
+			Response:         auth.Response(),
+		},
+	}); err != nil {
+		return err
 	}
-	tune := &connectionTune{}
 
-	if err := me.call(ok, tune); err != nil {
+	tune, err := me.openSecure(auth)
+	if err != nil {
 		// per spec, a connection can only be closed when it has been opened
 		// so at this point, we know it's an auth error, but the socket
 		// was closed instead.  Return a meaningful error.
@@ -448,16 +677,23 @@ func (me *Connection) openTune(config Config, auth Authentication) error {
 	// make an github issue.
 	me.Config.Channels = pick(config.Channels, int(tune.ChannelMax))
 
-	// Frame size includes headers and end byte (len(payload)+8), even if
-	// this is less than FrameMinSize, use what the server sends because the
-	// alternative is to stop the handshake here.
-	me.Config.FrameSize = pick(config.FrameSize, int(tune.FrameMax))
+	// Frame size includes headers and end byte (len(payload)+8).  Some
+	// brokers advertise 0 to mean "no limit", which would otherwise leave
+	// downstream framing code with no sane bound, so clamp to FrameMinSize.
+	me.Config.FrameSize = clampFrameSize(pick(config.FrameSize, int(tune.FrameMax)))
 
 	// Save this off for resetDeadline()
 	me.Config.Heartbeat = time.Second * time.Duration(pick(
 		int(config.Heartbeat/time.Second),
 		int(tune.Heartbeat)))
 
+	// Mirror the read side: give writes a deadline so a wedged socket can't
+	// block every publisher and the heartbeater goroutine indefinitely.
+	me.Config.WriteTimeout = config.WriteTimeout
+	if me.Config.WriteTimeout == 0 {
+		me.Config.WriteTimeout = me.Config.Heartbeat * 3
+	}
+
 	// "The client should start sending heartbeats after receiving a
 	// Connection.Tune method"
 	if me.Config.Heartbeat > 0 {
@@ -510,3 +746,12 @@ func pick(client, server int) int {
 	}
 	panic("unreachable")
 }
+
+// clampFrameSize floors a negotiated frame size at FrameMinSize, since some
+// brokers advertise a frame-max of 0 to mean "no limit".
+func clampFrameSize(frameSize int) int {
+	if frameSize < FrameMinSize {
+		return FrameMinSize
+	}
+	return frameSize
+}
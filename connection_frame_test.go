@@ -0,0 +1,26 @@
+// Copyright (c) 2012, Sean Treadway, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/streadway/amqp
+
+package amqp
+
+import "testing"
+
+func TestClampFrameSizeFloorsAtFrameMinSize(t *testing.T) {
+	cases := []struct {
+		frameSize, want int
+	}{
+		{0, FrameMinSize}, // broker advertised "no limit"
+		{FrameMinSize - 1, FrameMinSize},
+		{FrameMinSize, FrameMinSize},
+		{FrameMinSize + 1, FrameMinSize + 1},
+		{131072, 131072},
+	}
+
+	for _, c := range cases {
+		if got := clampFrameSize(c.frameSize); got != c.want {
+			t.Errorf("clampFrameSize(%d) = %d, want %d", c.frameSize, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+// Copyright (c) 2012, Sean Treadway, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/streadway/amqp
+
+package amqp
+
+import "testing"
+
+func TestDefaultClientPropertiesAdvertisesBlocked(t *testing.T) {
+	props := defaultClientProperties()
+
+	capabilities, ok := props["capabilities"].(Table)
+	if !ok {
+		t.Fatalf("expected capabilities to be a Table, got %T", props["capabilities"])
+	}
+
+	blocked, ok := capabilities["connection.blocked"].(bool)
+	if !ok || !blocked {
+		t.Fatalf("expected capabilities[connection.blocked] = true, got %#v", capabilities["connection.blocked"])
+	}
+}
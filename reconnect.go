@@ -0,0 +1,506 @@
+// Copyright (c) 2012, Sean Treadway, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/streadway/amqp
+
+package amqp
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff describes how long to wait between redial attempts made by a
+// ReconnectingConnection.  The delay starts at Base and doubles on every
+// consecutive failure, capped at Cap, and is randomized by +/- Jitter, a
+// fraction of the delay (0.5 means +/- 50%), to avoid a thundering herd of
+// clients reconnecting in lockstep.
+type Backoff struct {
+	Base   time.Duration
+	Cap    time.Duration
+	Jitter float64
+}
+
+func (b Backoff) delay(attempt int) time.Duration {
+	base := b.Base
+	if base <= 0 {
+		base = 500 * time.Millisecond
+	}
+
+	cap := b.Cap
+	if cap <= 0 {
+		cap = 30 * time.Second
+	}
+
+	d := base << uint(attempt)
+	if d <= 0 || d > cap {
+		d = cap
+	}
+
+	if b.Jitter > 0 {
+		spread := float64(d) * b.Jitter
+		d = d - time.Duration(spread) + time.Duration(rand.Float64()*2*spread)
+	}
+
+	return d
+}
+
+// ReconnectConfig is the Config used by the underlying Connections of a
+// ReconnectingConnection, plus the knobs that control redialing.
+type ReconnectConfig struct {
+	Config
+
+	// Backoff controls the delay between consecutive redial attempts.
+	Backoff Backoff
+
+	// MaxRetries bounds the number of consecutive redial attempts made after
+	// a connection is lost before giving up and firing NotifyClose.  Zero
+	// means retry forever.
+	MaxRetries int
+}
+
+// exchangeDeclareIntent records the arguments of a Channel.ExchangeDeclare
+// call so it can be replayed against a freshly redialed Channel.
+type exchangeDeclareIntent struct {
+	name, kind                            string
+	durable, autoDelete, internal, noWait bool
+	args                                  Table
+}
+
+// queueDeclareIntent records the arguments of a Channel.QueueDeclare call.
+type queueDeclareIntent struct {
+	name                                   string
+	durable, autoDelete, exclusive, noWait bool
+	args                                   Table
+}
+
+// queueBindIntent records the arguments of a Channel.QueueBind call.
+type queueBindIntent struct {
+	name, key, exchange string
+	noWait              bool
+	args                Table
+}
+
+// consumeIntent records the arguments of a Channel.Consume call along with
+// the chan Delivery that was handed back to the original caller, so
+// redelivery after a reconnect can be wired back into the same chan.
+type consumeIntent struct {
+	queue, consumer                     string
+	autoAck, exclusive, noLocal, noWait bool
+	args                                Table
+	deliveries                          chan Delivery
+}
+
+// qosIntent records the arguments of a Channel.Qos call.
+type qosIntent struct {
+	prefetchCount, prefetchSize int
+	global                      bool
+}
+
+// ReconnectingChannel wraps a Channel obtained from a ReconnectingConnection,
+// recording every declaration, binding, consumer and Confirm call made
+// against it so that they can be replayed in order against the Channel
+// opened on the new Connection after a reconnect.
+type ReconnectingChannel struct {
+	me sync.Mutex
+
+	conn *ReconnectingConnection
+	ch   *Channel
+
+	qos       *qosIntent
+	confirm   *bool
+	exchanges []exchangeDeclareIntent
+	queues    []queueDeclareIntent
+	binds     []queueBindIntent
+	consumes  []*consumeIntent
+	publishes []chan Confirmation
+}
+
+func (me *ReconnectingChannel) current() *Channel {
+	me.me.Lock()
+	defer me.me.Unlock()
+	return me.ch
+}
+
+// Qos behaves like Channel.Qos, and is replayed on every reopened Channel
+// after a reconnect.
+func (me *ReconnectingChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	if err := me.current().Qos(prefetchCount, prefetchSize, global); err != nil {
+		return err
+	}
+
+	me.me.Lock()
+	me.qos = &qosIntent{prefetchCount, prefetchSize, global}
+	me.me.Unlock()
+
+	return nil
+}
+
+// Confirm behaves like Channel.Confirm, and is replayed on every reopened
+// Channel after a reconnect.
+func (me *ReconnectingChannel) Confirm(noWait bool) error {
+	if err := me.current().Confirm(noWait); err != nil {
+		return err
+	}
+
+	me.me.Lock()
+	me.confirm = &noWait
+	me.me.Unlock()
+
+	return nil
+}
+
+// NotifyPublish behaves like Channel.NotifyPublish, and is re-registered
+// against every reopened Channel after a reconnect so the listener keeps
+// receiving confirmations instead of being left attached to the dead
+// Channel.
+func (me *ReconnectingChannel) NotifyPublish(confirm chan Confirmation) chan Confirmation {
+	me.current().NotifyPublish(confirm)
+
+	me.me.Lock()
+	me.publishes = append(me.publishes, confirm)
+	me.me.Unlock()
+
+	return confirm
+}
+
+// ExchangeDeclare behaves like Channel.ExchangeDeclare, and is replayed on
+// every reopened Channel after a reconnect.
+func (me *ReconnectingChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args Table) error {
+	if err := me.current().ExchangeDeclare(name, kind, durable, autoDelete, internal, noWait, args); err != nil {
+		return err
+	}
+
+	me.me.Lock()
+	me.exchanges = append(me.exchanges, exchangeDeclareIntent{name, kind, durable, autoDelete, internal, noWait, args})
+	me.me.Unlock()
+
+	return nil
+}
+
+// QueueDeclare behaves like Channel.QueueDeclare, and is replayed on every
+// reopened Channel after a reconnect.
+func (me *ReconnectingChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args Table) (Queue, error) {
+	q, err := me.current().QueueDeclare(name, durable, autoDelete, exclusive, noWait, args)
+	if err != nil {
+		return q, err
+	}
+
+	me.me.Lock()
+	me.queues = append(me.queues, queueDeclareIntent{name, durable, autoDelete, exclusive, noWait, args})
+	me.me.Unlock()
+
+	return q, nil
+}
+
+// QueueBind behaves like Channel.QueueBind, and is replayed on every
+// reopened Channel after a reconnect.
+func (me *ReconnectingChannel) QueueBind(name, key, exchange string, noWait bool, args Table) error {
+	if err := me.current().QueueBind(name, key, exchange, noWait, args); err != nil {
+		return err
+	}
+
+	me.me.Lock()
+	me.binds = append(me.binds, queueBindIntent{name, key, exchange, noWait, args})
+	me.me.Unlock()
+
+	return nil
+}
+
+// Consume behaves like Channel.Consume, except the returned chan Delivery is
+// kept open and fed from the Channel reopened after each reconnect, using
+// the same consumer tag and arguments as the original call.
+func (me *ReconnectingChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args Table) (chan Delivery, error) {
+	deliveries, err := me.current().Consume(queue, consumer, autoAck, exclusive, noLocal, noWait, args)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan Delivery)
+	intent := &consumeIntent{queue, consumer, autoAck, exclusive, noLocal, noWait, args, out}
+
+	me.me.Lock()
+	me.consumes = append(me.consumes, intent)
+	me.me.Unlock()
+
+	go forwardDeliveries(deliveries, out)
+
+	return out, nil
+}
+
+// forwardDeliveries relays deliveries from an upstream Channel consumer into
+// the caller-facing chan, returning when the upstream chan is closed so a
+// reconnect can resume forwarding from the new Channel's consumer.
+func forwardDeliveries(in chan Delivery, out chan Delivery) {
+	for d := range in {
+		out <- d
+	}
+}
+
+// reopen re-declares every recorded exchange, queue, binding, Qos and
+// Confirm setting, restarts every consumer and re-registers every
+// NotifyPublish listener against a freshly opened Channel on conn, then
+// swaps it in as the Channel this ReconnectingChannel delegates to.
+func (me *ReconnectingChannel) reopen(conn *Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	me.me.Lock()
+	qos := me.qos
+	confirm := me.confirm
+	exchanges := append([]exchangeDeclareIntent(nil), me.exchanges...)
+	queues := append([]queueDeclareIntent(nil), me.queues...)
+	binds := append([]queueBindIntent(nil), me.binds...)
+	consumes := append([]*consumeIntent(nil), me.consumes...)
+	publishes := append([]chan Confirmation(nil), me.publishes...)
+	me.me.Unlock()
+
+	if qos != nil {
+		if err := ch.Qos(qos.prefetchCount, qos.prefetchSize, qos.global); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range exchanges {
+		if err := ch.ExchangeDeclare(e.name, e.kind, e.durable, e.autoDelete, e.internal, e.noWait, e.args); err != nil {
+			return err
+		}
+	}
+
+	for _, q := range queues {
+		if _, err := ch.QueueDeclare(q.name, q.durable, q.autoDelete, q.exclusive, q.noWait, q.args); err != nil {
+			return err
+		}
+	}
+
+	for _, b := range binds {
+		if err := ch.QueueBind(b.name, b.key, b.exchange, b.noWait, b.args); err != nil {
+			return err
+		}
+	}
+
+	if confirm != nil {
+		if err := ch.Confirm(*confirm); err != nil {
+			return err
+		}
+	}
+
+	for _, l := range publishes {
+		ch.NotifyPublish(l)
+	}
+
+	for _, c := range consumes {
+		deliveries, err := ch.Consume(c.queue, c.consumer, c.autoAck, c.exclusive, c.noLocal, c.noWait, c.args)
+		if err != nil {
+			return err
+		}
+		go forwardDeliveries(deliveries, c.deliveries)
+	}
+
+	me.me.Lock()
+	me.ch = ch
+	me.me.Unlock()
+
+	return nil
+}
+
+// ReconnectingConnection wraps a Connection, transparently redialing with a
+// backoff when the underlying connection is lost, and replaying every
+// Channel opened through it so callers don't need to write their own
+// reconnect loop.
+type ReconnectingConnection struct {
+	me sync.Mutex
+
+	uri    string
+	config ReconnectConfig
+
+	conn *Connection
+
+	channels   []*ReconnectingChannel
+	reconnects []chan struct{}
+	closes     []chan *Error
+
+	closed bool
+}
+
+// DialReconnect dials amqpURI the same way DialConfig does, then watches the
+// resulting Connection and redials with config.Backoff whenever it is lost.
+func DialReconnect(amqpURI string, config ReconnectConfig) (*ReconnectingConnection, error) {
+	conn, err := DialConfig(amqpURI, config.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	me := &ReconnectingConnection{
+		uri:    amqpURI,
+		config: config,
+		conn:   conn,
+	}
+
+	go me.watch(conn)
+
+	return me, nil
+}
+
+// watch blocks on conn's NotifyClose and, on an unexpected close, redials
+// and replays every open Channel before resuming the watch on the new
+// Connection.
+func (me *ReconnectingConnection) watch(conn *Connection) {
+	err, ok := <-conn.NotifyClose(make(chan *Error, 1))
+	if !ok || err == nil {
+		// Close was requested by the caller, nothing to reconnect.
+		return
+	}
+
+	me.me.Lock()
+	if me.closed {
+		me.me.Unlock()
+		return
+	}
+	me.me.Unlock()
+
+	newConn, ok := me.redial()
+	if !ok {
+		me.terminal(err)
+		return
+	}
+
+	me.me.Lock()
+	if me.closed {
+		// Close() ran while redial() was sleeping through its backoff; it
+		// already closed the connection that was current at the time, but
+		// knows nothing about this one, so close it ourselves instead of
+		// adopting it and leaking the socket and this goroutine.
+		me.me.Unlock()
+		newConn.Close()
+		return
+	}
+	me.conn = newConn
+	channels := append([]*ReconnectingChannel(nil), me.channels...)
+	me.me.Unlock()
+
+	for _, ch := range channels {
+		if err := ch.reopen(newConn); err != nil {
+			newConn.Close()
+			me.terminal(&Error{Code: FrameError, Reason: err.Error()})
+			return
+		}
+	}
+
+	me.me.Lock()
+	listeners := append([]chan struct{}(nil), me.reconnects...)
+	me.me.Unlock()
+
+	// Reconnects are infrequent; block so a listener that hasn't reached
+	// its next select yet still receives this signal instead of silently
+	// missing it.
+	for _, l := range listeners {
+		l <- struct{}{}
+	}
+
+	go me.watch(newConn)
+}
+
+// redial retries DialConfig with me.config.Backoff delays in between,
+// stopping after me.config.MaxRetries consecutive failures (or retrying
+// forever when MaxRetries is zero).
+func (me *ReconnectingConnection) redial() (*Connection, bool) {
+	for attempt := 0; me.config.MaxRetries == 0 || attempt < me.config.MaxRetries; attempt++ {
+		time.Sleep(me.config.Backoff.delay(attempt))
+
+		conn, err := DialConfig(me.uri, me.config.Config)
+		if err == nil {
+			return conn, true
+		}
+	}
+
+	return nil, false
+}
+
+// terminal marks the ReconnectingConnection as permanently closed and fires
+// every NotifyClose listener with err.
+func (me *ReconnectingConnection) terminal(err *Error) {
+	me.me.Lock()
+	defer me.me.Unlock()
+
+	if me.closed {
+		return
+	}
+	me.closed = true
+
+	for _, c := range me.closes {
+		c <- err
+		close(c)
+	}
+}
+
+// NotifyReconnect registers a listener that receives a value every time the
+// underlying Connection has been successfully redialed and every known
+// Channel replayed against it, so the application can rebuild any state it
+// owns outside of amqp.  The send blocks until c is received from, so a
+// slow or momentarily-busy listener cannot cause a reconnect notification
+// to be silently dropped; give c enough buffer (or a dedicated goroutine)
+// to keep up if it also does other work between reconnects.
+func (me *ReconnectingConnection) NotifyReconnect(c chan struct{}) chan struct{} {
+	me.me.Lock()
+	defer me.me.Unlock()
+	me.reconnects = append(me.reconnects, c)
+	return c
+}
+
+// NotifyClose behaves like Connection.NotifyClose, except it only fires
+// once redialing has been given up on, either because Close was called,
+// because MaxRetries consecutive redial attempts failed, or because
+// replaying a Channel against the redialed Connection failed.
+func (me *ReconnectingConnection) NotifyClose(c chan *Error) chan *Error {
+	me.me.Lock()
+	defer me.me.Unlock()
+	me.closes = append(me.closes, c)
+	return c
+}
+
+// Channel opens a new ReconnectingChannel, recording every declaration,
+// binding, consumer and Confirm call made against it so it can be replayed
+// after a reconnect.
+func (me *ReconnectingConnection) Channel() (*ReconnectingChannel, error) {
+	me.me.Lock()
+	conn := me.conn
+	me.me.Unlock()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	rch := &ReconnectingChannel{conn: me, ch: ch}
+
+	me.me.Lock()
+	me.channels = append(me.channels, rch)
+	me.me.Unlock()
+
+	return rch, nil
+}
+
+// Close stops any future reconnect attempts and closes the current
+// underlying Connection.  It is safe to call after redialing has already
+// given up and fired NotifyClose, and safe to call more than once.
+func (me *ReconnectingConnection) Close() error {
+	me.me.Lock()
+	if me.closed {
+		me.me.Unlock()
+		return nil
+	}
+	me.closed = true
+	conn := me.conn
+	closes := append([]chan *Error(nil), me.closes...)
+	me.me.Unlock()
+
+	for _, c := range closes {
+		close(c)
+	}
+
+	return conn.Close()
+}
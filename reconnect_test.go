@@ -0,0 +1,54 @@
+// Copyright (c) 2012, Sean Treadway, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/streadway/amqp
+
+package amqp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelayDoublesUpToCap(t *testing.T) {
+	b := Backoff{Base: time.Second, Cap: 8 * time.Second}
+
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 1 * time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 8 * time.Second}, // clamped at Cap
+	}
+
+	for _, c := range cases {
+		if got := b.delay(c.attempt); got != c.want {
+			t.Errorf("delay(%d) = %v, want %v", c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestBackoffDelayDefaultsWhenUnset(t *testing.T) {
+	var b Backoff
+
+	if got := b.delay(0); got <= 0 {
+		t.Errorf("delay(0) with a zero Backoff should default to a positive base delay, got %v", got)
+	}
+}
+
+func TestBackoffDelayJitterStaysWithinSpread(t *testing.T) {
+	b := Backoff{Base: time.Second, Cap: time.Second, Jitter: 0.5}
+
+	min := time.Second / 2
+	max := 3 * time.Second / 2
+
+	for i := 0; i < 100; i++ {
+		got := b.delay(0)
+		if got < min || got > max {
+			t.Fatalf("delay() = %v, want within [%v, %v]", got, min, max)
+		}
+	}
+}
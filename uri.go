@@ -0,0 +1,106 @@
+// Copyright (c) 2012, Sean Treadway, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/streadway/amqp
+
+package amqp
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var errURIScheme = errors.New("AMQP scheme must be either 'amqp://' or 'amqps://'")
+
+var schemePorts = map[string]int{
+	"amqp":  5672,
+	"amqps": 5671,
+}
+
+var defaultURI = URI{
+	Scheme:   "amqp",
+	Host:     "localhost",
+	Port:     5672,
+	Username: "guest",
+	Password: "guest",
+	Vhost:    "/",
+}
+
+// URI represents a parsed AMQP URI string.
+type URI struct {
+	Scheme   string
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Vhost    string
+}
+
+// ParseURI attempts to parse the given AMQP URI according to the spec.  The
+// scheme must be either "amqp" for a plain TCP connection or "amqps" for a
+// TLS connection, defaulting the port to 5672 or 5671 respectively when not
+// present in the URI.
+//
+//	amqp_URI       = "amqp://" amqp_authority [ "/" vhost ] [ "?" query ]
+//	amqps_URI      = "amqps://" amqp_authority [ "/" vhost ] [ "?" query ]
+func ParseURI(uri string) (URI, error) {
+	builder := defaultURI
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return builder, err
+	}
+
+	defaultPort, ok := schemePorts[u.Scheme]
+	if !ok {
+		return builder, errURIScheme
+	}
+	builder.Scheme = u.Scheme
+
+	host := u.Hostname()
+	if len(host) > 0 {
+		builder.Host = host
+	}
+
+	if port := u.Port(); len(port) > 0 {
+		port32, err := strconv.ParseInt(port, 10, 32)
+		if err != nil {
+			return builder, err
+		}
+		builder.Port = int(port32)
+	} else {
+		builder.Port = defaultPort
+	}
+
+	if u.User != nil {
+		builder.Username = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			builder.Password = password
+		}
+	}
+
+	if len(u.Path) > 0 {
+		if strings.HasPrefix(u.Path, "/") {
+			if u.Path == "/" {
+				builder.Vhost = "/"
+			} else {
+				builder.Vhost = u.Path[1:]
+			}
+		} else {
+			builder.Vhost = u.Path
+		}
+	}
+
+	return builder, nil
+}
+
+// PlainAuth returns a PlainAuth structure based on the parsed URI's
+// Username and Password fields, ready to be passed in Config.SASL.
+func (uri URI) PlainAuth() *PlainAuth {
+	return &PlainAuth{
+		Username: uri.Username,
+		Password: uri.Password,
+	}
+}